@@ -6,6 +6,8 @@ import (
 	"math/rand"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/soundcloud/roshi/cluster"
@@ -32,6 +34,11 @@ type Farm struct {
 	walkCompleted   chan bool
 	ratePolice      RatePolice
 	instrumentation instrumentation.Instrumentation
+	latencies       []*clusterLatency
+	frozenMtx       sync.Mutex
+	frozen          []bool
+	repairsPending  []int64
+	ring            *replicationRing
 }
 
 // New creates and returns a new Farm.
@@ -43,29 +50,42 @@ type Farm struct {
 //
 // The repairer handles read-repairs (may be nil for no repairs).
 //
-// The walkerRate defines the max number of keys the data walker reads
-// per second. If 0, no data walk will happen.
+// walkTarget defines the duration in which the data walker aims to sweep
+// the whole farm exactly once. The per-batch pacing is recomputed after
+// every completed sweep from the observed key count, so operators don't
+// need to re-tune anything as the dataset grows. If 0, no data walk will
+// happen.
 //
 // When the data walker finishes a walk of the whole farm, it will
 // send true to the walkCompleted channel (but only if it is ready to
 // receive at that moment). (Use nil if you don't want to receive
 // anything.)
 //
-// The RatePolice is used to limit the walk rate so that the sum of
-// keys read by the walker and keys read by actual queries does not
-// exceed the walkerRate. (rp can be nil, in which case no limits will
-// be imposed. Only do that with a walkerRate of 0.)
+// The RatePolice is used to cap the walk rate so that the sum of keys
+// read by the walker and keys read by actual queries does not exceed
+// its budget, even if the pace computed from walkTarget would otherwise
+// exceed it. (rp can be nil, in which case no limits will be imposed.
+// Only do that with a walkTarget of 0.)
 //
 // Set instr to nil if you don't need instrumentation.
+//
+// replicationFactor, if greater than 0, restricts each key to a stable
+// subset of replicationFactor clusters (chosen by a consistent hash over
+// common.KeyScoreMember.Key) instead of every cluster in the farm.
+// writeQuorum is then interpreted within that subset. This lets a farm
+// scale to many clusters purely for capacity rather than only for
+// availability. Pass 0 to keep the original behavior of every key living
+// on every cluster.
 func New(
 	clusters []cluster.Cluster,
 	writeQuorum int,
 	readStrategy ReadStrategy,
 	repairer Repairer,
-	walkerRate int,
+	walkTarget time.Duration,
 	walkCompleted chan bool,
 	rp RatePolice,
 	instr instrumentation.Instrumentation,
+	replicationFactor int,
 ) *Farm {
 	if rp == nil {
 		rp = NewNoPolice()
@@ -82,10 +102,15 @@ func New(
 		walkCompleted:   walkCompleted,
 		ratePolice:      rp,
 		instrumentation: instr,
+		frozen:          make([]bool, len(clusters)),
+		repairsPending:  make([]int64, len(clusters)),
+	}
+	if replicationFactor > 0 && replicationFactor < len(clusters) {
+		farm.ring = newReplicationRing(len(clusters), replicationFactor)
 	}
 	farm.readStrategy = readStrategy(farm)
 	farm.repairer = repairer(farm)
-	go farm.startWalker(walkerRate)
+	go farm.startWalker(walkTarget)
 	return farm
 }
 
@@ -125,12 +150,22 @@ func (f *Farm) Delete(tuples []common.KeyScoreMember) error {
 	)
 }
 
-func (f *Farm) startWalker(walkerRate int) {
-	if walkerRate == 0 {
+// minWalkWaitInterval is the smallest pacing interval startWalker will
+// ever compute, so that a sparse or just-starting keyspace can't make it
+// busy-loop.
+const minWalkWaitInterval = time.Millisecond
+
+// walkBatchSize is the number of keys read between pacing waits. It's
+// small enough to keep the pacing responsive without making every single
+// key its own Select call.
+const walkBatchSize = 100
+
+func (f *Farm) startWalker(walkTarget time.Duration) {
+	if walkTarget <= 0 {
 		return
 	}
 	keyChannel := make(chan string)
-	walkCompleted := make(chan bool, 1)
+	passCompleted := make(chan bool, 1)
 
 	// Start a goroutine that endlessly iterates through all
 	// clusters in random order. (It will visit each cluster
@@ -140,6 +175,9 @@ func (f *Farm) startWalker(walkerRate int) {
 		for {
 			anythingSent := false
 			for _, i := range rand.Perm(len(f.clusters)) {
+				if f.isFrozen(i) {
+					continue
+				}
 				for key := range f.clusters[i].Keys() {
 					keyChannel <- key
 					anythingSent = true
@@ -148,7 +186,7 @@ func (f *Farm) startWalker(walkerRate int) {
 			f.instrumentation.KeysFarmCompleted()
 			// Report completed if not already done so.
 			select {
-			case walkCompleted <- true:
+			case passCompleted <- true:
 			default:
 			}
 			if !anythingSent {
@@ -159,7 +197,48 @@ func (f *Farm) startWalker(walkerRate int) {
 		}
 	}()
 
+	// waitInterval is how long we pause between batches. It starts at
+	// the minimum (be eager) and is recalibrated after every completed
+	// pass, once we know roughly how big the keyspace is and how long
+	// actually reading it takes.
+	waitInterval := minWalkWaitInterval
+	batchesThisPass := 0
+	var selectCost time.Duration
+	timer := time.NewTimer(waitInterval)
+
 	for {
+		select {
+		case <-passCompleted:
+			if batchesThisPass > 0 {
+				// Aim for the next pass to take walkTarget in
+				// total: spend estimatedScanCost doing real
+				// work, and spread the rest as wait time
+				// evenly across every batch.
+				estimatedScanCost := selectCost
+				remaining := walkTarget - estimatedScanCost
+				waitInterval = remaining / time.Duration(batchesThisPass)
+				if waitInterval < minWalkWaitInterval {
+					waitInterval = minWalkWaitInterval
+				}
+			}
+			batchesThisPass, selectCost = 0, 0
+			// Report completion if we were asked to do so.
+			if f.walkCompleted != nil {
+				select {
+				case f.walkCompleted <- true:
+				default:
+				}
+			}
+		default:
+		}
+
+		<-timer.C
+		timer.Reset(waitInterval)
+
+		// The ratePolice still gets the final say: even if our
+		// computed pace implies a higher rate, we never request
+		// more than it's willing to grant.
+		walkerRate := ratePolicePace(waitInterval, walkBatchSize)
 		batchSize := f.ratePolice.Request(walkerRate)
 		if batchSize <= 0 {
 			// Too much traffic. Wait for a sec and try again.
@@ -167,32 +246,35 @@ func (f *Farm) startWalker(walkerRate int) {
 			time.Sleep(time.Second)
 			continue
 		}
-		// Safeguard against excessive batchSize.
-		if batchSize > 10*walkerRate {
-			batchSize = 10 * walkerRate
+		if batchSize > walkBatchSize {
+			batchSize = walkBatchSize
 		}
-		keys := []string{}
+		keys := make([]string, 0, batchSize)
 		for ; batchSize > 0; batchSize-- {
 			keys = append(keys, <-keyChannel)
 		}
+		began := time.Now()
 		// We are only interested in triggering the
 		// read repair, so we throw away the results
 		// and don't check for errors.
 		f.Select(keys, 0, MaxInt)
-		// Report completion if we were asked to do so _and_ a
-		// walk was actually reported _and_ the report channel
-		// is ready to receive.
-		if f.walkCompleted != nil {
-			select {
-			case <-walkCompleted:
-				select {
-				case f.walkCompleted <- true:
-				default:
-				}
-			default:
-			}
-		}
+		selectCost += time.Now().Sub(began)
+		batchesThisPass++
+	}
+}
+
+// ratePolicePace converts a target pacing interval and batch size into an
+// equivalent keys-per-second rate, suitable for passing to
+// RatePolice.Request.
+func ratePolicePace(waitInterval time.Duration, batchSize int) int {
+	if waitInterval <= 0 {
+		return batchSize
+	}
+	rate := float64(batchSize) / waitInterval.Seconds()
+	if rate < 1 {
+		return 1
 	}
+	return int(rate)
 }
 
 func (f *Farm) write(
@@ -212,16 +294,75 @@ func (f *Farm) write(
 		instr.recordDuration(d / time.Duration(len(tuples)))
 	}(time.Now())
 
-	// Scatter
-	errChan := make(chan error, len(f.clusters))
-	for _, c := range f.clusters {
+	// Group the tuples by the set of clusters that owns each one. With
+	// no replication ring configured, that's a single group holding
+	// every tuple and every cluster, same as before.
+	byKey := map[string][]common.KeyScoreMember{}
+	keys := make([]string, 0, len(tuples))
+	for _, tuple := range tuples {
+		if _, ok := byKey[tuple.Key]; !ok {
+			keys = append(keys, tuple.Key)
+		}
+		byKey[tuple.Key] = append(byKey[tuple.Key], tuple)
+	}
+	groups := f.groupByOwners(keys)
+
+	// Scatter + gather, one group at a time, in parallel.
+	groupErrChan := make(chan error, len(groups))
+	for _, g := range groups {
+		groupTuples := make([]common.KeyScoreMember, 0, len(tuples))
+		for _, key := range g.keys {
+			groupTuples = append(groupTuples, byKey[key]...)
+		}
+		go func(indices []int, groupTuples []common.KeyScoreMember) {
+			groupErrChan <- f.writeToOwners(indices, groupTuples, action)
+		}(g.indices, groupTuples)
+	}
+
+	errors := []string{}
+	for i := 0; i < len(groups); i++ {
+		if err := <-groupErrChan; err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	// Report
+	if len(errors) > 0 {
+		instr.quorumFailure()
+		return fmt.Errorf("no quorum (%s)", strings.Join(errors, "; "))
+	}
+	return nil
+}
+
+// writeToOwners scatters action to the clusters at indices and gathers
+// results until quorum is reached or every cluster has responded. need is
+// f.writeQuorum relaxed by one if any cluster in indices is frozen (so a
+// drained-for-maintenance cluster's inevitable failure to ack doesn't
+// itself cause spurious quorum failures), capped at len(indices) so a
+// small replication-factor subset can still reach quorum. See quorumFor:
+// the relaxation is capped at one cluster's worth no matter how many of
+// indices are actually frozen.
+func (f *Farm) writeToOwners(
+	indices []int,
+	tuples []common.KeyScoreMember,
+	action func(cluster.Cluster, []common.KeyScoreMember) error,
+) error {
+	errChan := make(chan error, len(indices))
+	frozenCount := 0
+	for _, i := range indices {
+		if f.isFrozen(i) {
+			frozenCount++
+		}
 		go func(c cluster.Cluster) {
 			errChan <- action(c, tuples)
-		}(c)
+		}(f.clusters[i])
 	}
 
-	// Gather
-	errors, got, need := []string{}, 0, f.writeQuorum
+	need := quorumFor(f.writeQuorum, frozenCount)
+	if need > len(indices) {
+		need = len(indices)
+	}
+	errors, got := []string{}, 0
 	haveQuorum := func() bool { return got-len(errors) >= need }
 	for i := 0; i < cap(errChan); i++ {
 		err := <-errChan
@@ -234,10 +375,8 @@ func (f *Farm) write(
 		}
 	}
 
-	// Report
 	if !haveQuorum() {
-		instr.quorumFailure()
-		return fmt.Errorf("no quorum (%s)", strings.Join(errors, "; "))
+		return fmt.Errorf("no quorum for %d keys among clusters %v (%s)", len(tuples), indices, strings.Join(errors, "; "))
 	}
 	return nil
 }
@@ -0,0 +1,155 @@
+package farm
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Freeze marks the cluster at clusterIndex as drained for maintenance, so
+// that it can be taken down (e.g. for a Redis upgrade) without the rest of
+// the farm treating it as a failure. While frozen, a cluster:
+//
+//   - still receives writes, so that it stays current for whenever it's
+//     thawed, but at most one frozen cluster at a time is exempted from
+//     counting against write quorum (see quorumFor) -- freezing more than
+//     that doesn't relax quorum any further;
+//   - is skipped by every ReadStrategy;
+//   - is skipped by the background walker.
+//
+// Freeze returns an error, and freezes nothing, if doing so would make
+// write quorum impossible to reach. ctx is only checked for prior
+// cancelation; Freeze does no blocking I/O of its own.
+func (f *Farm) Freeze(ctx context.Context, clusterIndex int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if clusterIndex < 0 || clusterIndex >= len(f.clusters) {
+		return fmt.Errorf("cluster index %d out of range [0,%d)", clusterIndex, len(f.clusters))
+	}
+
+	f.frozenMtx.Lock()
+	defer f.frozenMtx.Unlock()
+
+	if f.frozen[clusterIndex] {
+		return nil
+	}
+
+	frozenCount := 1
+	for i, frozen := range f.frozen {
+		if frozen && i != clusterIndex {
+			frozenCount++
+		}
+	}
+	if active := len(f.clusters) - frozenCount; active < quorumFor(f.writeQuorum, frozenCount) {
+		return fmt.Errorf(
+			"freezing cluster %d would leave only %d of %d clusters active, making write quorum %d impossible",
+			clusterIndex, active, len(f.clusters), f.writeQuorum,
+		)
+	}
+
+	f.frozen[clusterIndex] = true
+	return nil
+}
+
+// Thaw reverses a prior Freeze, immediately making clusterIndex eligible
+// again for reads, the walker, and write quorum.
+func (f *Farm) Thaw(clusterIndex int) error {
+	if clusterIndex < 0 || clusterIndex >= len(f.clusters) {
+		return fmt.Errorf("cluster index %d out of range [0,%d)", clusterIndex, len(f.clusters))
+	}
+	f.frozenMtx.Lock()
+	defer f.frozenMtx.Unlock()
+	f.frozen[clusterIndex] = false
+	return nil
+}
+
+// FreezeAll freezes clusters one at a time, stopping as soon as another
+// Freeze would make write quorum unreachable (or after freezing every
+// cluster but one, whichever comes first). Since quorum is only ever
+// relaxed by one cluster's worth (see quorumFor), FreezeAll only reaches
+// down to a single active cluster for farms configured with a write
+// quorum of 2 or less; tighter quorums stop earlier and return the error
+// Freeze produced. It's a convenience wrapper around Freeze for
+// maintenance windows that touch the whole farm one cluster at a time.
+func (f *Farm) FreezeAll(ctx context.Context) error {
+	for i := 0; i < len(f.clusters)-1; i++ {
+		if err := f.Freeze(ctx, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ThawAll reverses FreezeAll, thawing every cluster in the farm.
+func (f *Farm) ThawAll() error {
+	for i := range f.clusters {
+		if err := f.Thaw(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FreezeState returns, for each cluster in the farm (in the same order
+// passed to New), whether it's currently frozen. It's meant to be polled
+// by instrumentation.
+func (f *Farm) FreezeState() []bool {
+	f.frozenMtx.Lock()
+	defer f.frozenMtx.Unlock()
+	state := make([]bool, len(f.frozen))
+	copy(state, f.frozen)
+	return state
+}
+
+// PendingRepairs returns, for each cluster in the farm (in the same order
+// passed to New), a running count of reads that found a difference among
+// the clusters owning the keys read. Every owning cluster of such a read is
+// counted, frozen ones included, not only the ones that lacked the data;
+// the counts are cumulative and never decrease. It's meant to be polled by
+// instrumentation.
+func (f *Farm) PendingRepairs() []int64 {
+	counts := make([]int64, len(f.repairsPending))
+	for i := range counts {
+		counts[i] = atomic.LoadInt64(&f.repairsPending[i])
+	}
+	return counts
+}
+
+// recordRepair increments the pending-repair count for every cluster in
+// indices. It's called by a ReadStrategy whenever a read found a
+// difference between clusters that the repairer needs to reconcile.
+func (f *Farm) recordRepair(indices []int) {
+	for _, i := range indices {
+		atomic.AddInt64(&f.repairsPending[i], 1)
+	}
+}
+
+// isFrozen reports whether the cluster at index i is currently frozen.
+func (f *Farm) isFrozen(i int) bool {
+	f.frozenMtx.Lock()
+	defer f.frozenMtx.Unlock()
+	return f.frozen[i]
+}
+
+// maxQuorumRelaxation bounds how much frozen clusters can relax write
+// quorum. It's fixed at one cluster's worth regardless of how many
+// clusters actually happen to be frozen at once, so quorum expectations
+// stay tied to the configured writeQuorum rather than eroding toward 1
+// as more of the farm goes down for maintenance.
+const maxQuorumRelaxation = 1
+
+// quorumFor computes the write quorum, relaxed by at most
+// maxQuorumRelaxation when one or more clusters are frozen, never
+// dropping below 1.
+func quorumFor(writeQuorum, frozenCount int) int {
+	relaxation := frozenCount
+	if relaxation > maxQuorumRelaxation {
+		relaxation = maxQuorumRelaxation
+	}
+	q := writeQuorum - relaxation
+	if q < 1 {
+		q = 1
+	}
+	return q
+}
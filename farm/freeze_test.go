@@ -0,0 +1,101 @@
+package farm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+func TestQuorumFor(t *testing.T) {
+	for _, testCase := range []struct {
+		writeQuorum, frozenCount, want int
+	}{
+		{3, 0, 3},
+		{3, 1, 2},
+		{3, 2, 2}, // relaxation is capped at one cluster, not two
+		{3, 5, 2}, // ...no matter how many are actually frozen
+		{2, 1, 1},
+		{1, 0, 1},
+		{1, 1, 1}, // never drops below 1
+	} {
+		if got := quorumFor(testCase.writeQuorum, testCase.frozenCount); got != testCase.want {
+			t.Errorf("quorumFor(%d, %d) = %d, want %d", testCase.writeQuorum, testCase.frozenCount, got, testCase.want)
+		}
+	}
+}
+
+func newTestFarm(numClusters, writeQuorum int) *Farm {
+	return &Farm{
+		clusters:       make([]cluster.Cluster, numClusters),
+		writeQuorum:    writeQuorum,
+		frozen:         make([]bool, numClusters),
+		repairsPending: make([]int64, numClusters),
+	}
+}
+
+func TestFreezeRejectsWhenQuorumWouldBreak(t *testing.T) {
+	f := newTestFarm(3, 3)
+	if err := f.Freeze(context.Background(), 0); err != nil {
+		t.Fatalf("Freeze(0) = %v, want nil", err)
+	}
+	if err := f.Freeze(context.Background(), 1); err == nil {
+		t.Fatalf("Freeze(1) = nil, want an error (would leave write quorum %d unreachable with 1 active cluster)", f.writeQuorum)
+	}
+}
+
+func TestFreezeAllLeavesOneClusterActive(t *testing.T) {
+	// Quorum is only ever relaxed by one cluster's worth (see quorumFor),
+	// so FreezeAll can only ever reach a single active cluster when the
+	// configured write quorum is loose enough (<=2) to survive that.
+	for _, n := range []int{1, 3, 5, 7} {
+		for _, wq := range []int{1, 2} {
+			f := newTestFarm(n, wq)
+			if err := f.FreezeAll(context.Background()); err != nil {
+				t.Fatalf("FreezeAll on %d clusters (writeQuorum=%d) = %v, want nil", n, wq, err)
+			}
+			active := 0
+			for _, frozen := range f.FreezeState() {
+				if !frozen {
+					active++
+				}
+			}
+			if active != 1 {
+				t.Errorf("FreezeAll on %d clusters (writeQuorum=%d) left %d active, want exactly 1", n, wq, active)
+			}
+		}
+	}
+}
+
+func TestFreezeAllStopsBeforeBreakingQuorum(t *testing.T) {
+	// With a majority write quorum, FreezeAll can't safely drain down to
+	// a single active cluster -- it must stop (and return Freeze's
+	// error) as soon as doing so would make quorum unreachable.
+	f := newTestFarm(5, 3)
+	if err := f.FreezeAll(context.Background()); err == nil {
+		t.Fatal("FreezeAll on 5 clusters (writeQuorum=3) = nil, want an error once quorum would become unreachable")
+	}
+	active := 0
+	for _, frozen := range f.FreezeState() {
+		if !frozen {
+			active++
+		}
+	}
+	if active < 2 {
+		t.Errorf("FreezeAll on 5 clusters (writeQuorum=3) left only %d active, want it to stop at quorumFor(3, 1)=2", active)
+	}
+}
+
+func TestRecordRepairIncrementsOnlyTheGivenClusters(t *testing.T) {
+	f := newTestFarm(3, 1)
+	f.recordRepair([]int{0, 2})
+	f.recordRepair([]int{2})
+
+	got := f.PendingRepairs()
+	want := []int64{1, 0, 2}
+	for i, w := range want {
+		if got[i] != w {
+			t.Errorf("PendingRepairs()[%d] = %d, want %d", i, got[i], w)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package farm
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// NewTokenBucketPolice returns a RatePolice backed by a single token bucket
+// shared between live Select traffic and the background walker. The bucket
+// is refilled at totalRate tokens per second, up to a capacity of
+// walkerBurst tokens.
+//
+// Report debits the bucket by n tokens every time live traffic comes
+// through Select. It never blocks, and is allowed to push the bucket
+// negative (down to -walkerBurst) so that a burst of live reads is never
+// held up by the walker's own accounting.
+//
+// Request is called by the walker to ask for up to walkerRate keys. It
+// returns the number of tokens currently available, capped at
+// walkerRate. If the bucket is at or below zero -- because live traffic
+// has used up (or overdrawn) the budget -- Request returns 0 and the
+// walker is expected to back off.
+func NewTokenBucketPolice(totalRate, walkerBurst int) RatePolice {
+	return &tokenBucketPolice{
+		tokens:      float64(walkerBurst),
+		capacity:    float64(walkerBurst),
+		walkerBurst: float64(walkerBurst),
+		rate:        float64(totalRate),
+		last:        time.Now(),
+	}
+}
+
+// tokenBucketPolice implements RatePolice via a token bucket refilled at a
+// fixed rate and shared between Report (live reads) and Request (walker
+// reads).
+type tokenBucketPolice struct {
+	sync.Mutex
+	tokens      float64
+	capacity    float64
+	walkerBurst float64
+	rate        float64 // tokens per second
+	last        time.Time
+}
+
+// Report implements RatePolice.
+func (p *tokenBucketPolice) Report(n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.refill()
+	p.tokens -= float64(n)
+	if p.tokens < -p.walkerBurst {
+		p.tokens = -p.walkerBurst
+	}
+}
+
+// Request implements RatePolice.
+func (p *tokenBucketPolice) Request(walkerRate int) int {
+	p.Lock()
+	defer p.Unlock()
+	p.refill()
+	if p.tokens <= 0 {
+		return 0
+	}
+	n := int(math.Floor(p.tokens))
+	if n > walkerRate {
+		n = walkerRate
+	}
+	p.tokens -= float64(n)
+	return n
+}
+
+// refill adds tokens for the time elapsed since the last refill, capped at
+// the bucket's capacity. Callers must hold p.Mutex.
+func (p *tokenBucketPolice) refill() {
+	now := time.Now()
+	elapsed := now.Sub(p.last).Seconds()
+	p.last = now
+	p.tokens += elapsed * p.rate
+	if p.tokens > p.capacity {
+		p.tokens = p.capacity
+	}
+}
@@ -0,0 +1,33 @@
+package farm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketPoliceRequest(t *testing.T) {
+	for _, testCase := range []struct {
+		name       string
+		tokens     float64
+		walkerRate int
+		want       int
+	}{
+		{"plenty of tokens, rate is the limit", 1000, 10, 10},
+		{"fewer tokens than the requested rate", 5, 10, 5},
+		{"no tokens available", 0, 10, 0},
+		{"overdrawn by live traffic", -5, 10, 0},
+	} {
+		p := &tokenBucketPolice{tokens: testCase.tokens, capacity: 1000, walkerBurst: 1000, rate: 0, last: time.Now()}
+		if got := p.Request(testCase.walkerRate); got != testCase.want {
+			t.Errorf("%s: Request(%d) = %d, want %d", testCase.name, testCase.walkerRate, got, testCase.want)
+		}
+	}
+}
+
+func TestTokenBucketPoliceReportClampsAtNegativeBurst(t *testing.T) {
+	p := &tokenBucketPolice{tokens: 0, capacity: 100, walkerBurst: 10, rate: 0, last: time.Now()}
+	p.Report(25)
+	if p.tokens != -10 {
+		t.Errorf("Report(25) left tokens at %v, want -10 (clamped to -walkerBurst)", p.tokens)
+	}
+}
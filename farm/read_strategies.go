@@ -0,0 +1,208 @@
+package farm
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// clusterLatency tracks a moving-window average of observed read latencies
+// for a single cluster. It's used by SendLatencyWeighted to rank clusters
+// from fastest to slowest.
+type clusterLatency struct {
+	sync.Mutex
+	samples []time.Duration
+	max     int
+}
+
+func newClusterLatency(maxSamples int) *clusterLatency {
+	return &clusterLatency{max: maxSamples}
+}
+
+// record appends a new observed latency, dropping the oldest sample once
+// the window is full.
+func (l *clusterLatency) record(d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.samples = append(l.samples, d)
+	if len(l.samples) > l.max {
+		l.samples = l.samples[len(l.samples)-l.max:]
+	}
+}
+
+// estimate returns the average of the recorded samples, or 0 if nothing
+// has been recorded yet (i.e. the cluster should be tried first).
+func (l *clusterLatency) estimate() time.Duration {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.samples) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, s := range l.samples {
+		sum += s
+	}
+	return sum / time.Duration(len(l.samples))
+}
+
+// SendLatencyWeighted returns a ReadStrategy that sends each read to the p
+// clusters with the lowest recently-observed latency first, in parallel,
+// and only scatters to the remaining clusters if those p clusters fail to
+// produce a response from every one of them within a timeout derived from
+// their own latency estimates (2x the slowest of the chosen set).
+//
+// Per-cluster latency is tracked with a moving window of up to
+// maxLatencySamples observations, updated on every Select. The resulting
+// latency estimates are exposed via Farm.Latencies for instrumentation.
+//
+// This trades a small amount of read-repair coverage (clusters that are
+// never queried contribute nothing to the union/difference computation for
+// a given read) for materially lower tail latency, which matters most for
+// farms spread across multiple regions.
+//
+// If the farm has a replication ring configured (see New's
+// replicationFactor argument), a read only ever considers the clusters
+// that own each key, ranking and scattering within that owning subset
+// rather than across the whole farm.
+func SendLatencyWeighted(p int, maxLatencySamples int) ReadStrategy {
+	if p < 1 {
+		p = 1
+	}
+	return func(farm *Farm) coreReadStrategy {
+		latencies := make([]*clusterLatency, len(farm.clusters))
+		for i := range latencies {
+			latencies[i] = newClusterLatency(maxLatencySamples)
+		}
+		farm.latencies = latencies
+
+		rankedIndices := func(candidates []int) []int {
+			frozen := farm.FreezeState()
+			indices := make([]int, 0, len(candidates))
+			for _, i := range candidates {
+				if !frozen[i] {
+					indices = append(indices, i)
+				}
+			}
+			sort.Slice(indices, func(a, b int) bool {
+				return latencies[indices[a]].estimate() < latencies[indices[b]].estimate()
+			})
+			return indices
+		}
+
+		readOne := func(i int, keys []string, offset, limit int) tupleSet {
+			began := time.Now()
+			m, err := farm.clusters[i].Select(keys, offset, limit)
+			latencies[i].record(time.Now().Sub(began))
+			if err != nil {
+				return nil
+			}
+			s := tupleSet{}
+			for _, tuples := range m {
+				for _, tuple := range tuples {
+					s.add(tuple)
+				}
+			}
+			return s
+		}
+
+		// scatter reads from every one of indices in parallel and gathers
+		// the results. If timeout is greater than 0, scatter stops
+		// waiting and returns whatever it has as soon as timeout
+		// elapses, leaving any still-outstanding reads to finish (and
+		// keep updating latencies) in the background.
+		scatter := func(indices []int, keys []string, offset, limit int, timeout time.Duration) []tupleSet {
+			results := make(chan tupleSet, len(indices))
+			for _, i := range indices {
+				go func(i int) { results <- readOne(i, keys, offset, limit) }(i)
+			}
+			var deadline <-chan time.Time
+			if timeout > 0 {
+				deadline = time.After(timeout)
+			}
+			sets := make([]tupleSet, 0, len(indices))
+			for range indices {
+				select {
+				case s := <-results:
+					if s != nil {
+						sets = append(sets, s)
+					}
+				case <-deadline:
+					return sets
+				}
+			}
+			return sets
+		}
+
+		// fastTimeout derives the deadline for the fast set from its own
+		// latency estimates: 2x the slowest of the chosen clusters. A
+		// cluster with no samples yet estimates 0, so until the fast set
+		// has been measured at least once, there's no basis for a
+		// timeout and the gather simply waits for all of them.
+		fastTimeout := func(fast []int) time.Duration {
+			var slowest time.Duration
+			for _, i := range fast {
+				if e := latencies[i].estimate(); e > slowest {
+					slowest = e
+				}
+			}
+			return 2 * slowest
+		}
+
+		return func(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+			response := map[string][]common.KeyScoreMember{}
+
+			for _, group := range farm.groupByOwners(keys) {
+				indices := rankedIndices(group.indices)
+				n := p
+				if n > len(indices) {
+					n = len(indices)
+				}
+				fast, slow := indices[:n], indices[n:]
+
+				sets := scatter(fast, group.keys, offset, limit, fastTimeout(fast))
+
+				if len(sets) < len(fast) && len(slow) > 0 {
+					sets = append(sets, scatter(slow, group.keys, offset, limit, 0)...)
+				}
+
+				union, difference := unionDifference(sets)
+				if len(difference) > 0 {
+					farm.repairer(union, difference)
+					farm.recordRepair(group.indices)
+				}
+
+				for _, key := range group.keys {
+					response[key] = []common.KeyScoreMember{}
+				}
+				for tuple := range union {
+					response[tuple.Key] = append(response[tuple.Key], tuple)
+				}
+			}
+
+			for key, tuples := range response {
+				s := tupleSet{}
+				for _, t := range tuples {
+					s.add(t)
+				}
+				response[key] = s.orderedLimitedSlice(limit)
+			}
+			return response, nil
+		}
+	}
+}
+
+// Latencies returns the current per-cluster read-latency estimates, in the
+// same order as the clusters passed to New. It returns nil if the active
+// ReadStrategy doesn't track latency (only SendLatencyWeighted does).
+func (f *Farm) Latencies() []time.Duration {
+	if f.latencies == nil {
+		return nil
+	}
+	estimates := make([]time.Duration, len(f.latencies))
+	for i, l := range f.latencies {
+		estimates[i] = l.estimate()
+	}
+	return estimates
+}
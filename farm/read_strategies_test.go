@@ -0,0 +1,27 @@
+package farm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClusterLatencyEstimate(t *testing.T) {
+	l := newClusterLatency(3)
+	if got := l.estimate(); got != 0 {
+		t.Fatalf("estimate() on empty window = %v, want 0", got)
+	}
+
+	l.record(10 * time.Millisecond)
+	l.record(20 * time.Millisecond)
+	if got, want := l.estimate(), 15*time.Millisecond; got != want {
+		t.Errorf("estimate() after two samples = %v, want %v", got, want)
+	}
+
+	// A third sample fills the window (max=3); a fourth exceeds it and
+	// evicts the oldest.
+	l.record(30 * time.Millisecond)
+	l.record(60 * time.Millisecond)
+	if got, want := l.estimate(), (20+30+60)*time.Millisecond/3; got != want {
+		t.Errorf("estimate() after window overflow = %v, want %v (oldest sample should be dropped)", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package farm
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// replicationRing maps each key to a stable subset of clusters of size
+// replicationFactor, rather than every cluster in the farm. It lets a farm
+// scale to many clusters purely for capacity (sharding), not just
+// availability (replication), which is the pattern ring-based distributed
+// caches and Redis Cluster use for placement.
+//
+// The primary owner for a key is chosen with a jump consistent hash over
+// the cluster count; the remaining owners are the next replicationFactor-1
+// clusters walking around the ring. This keeps the implementation free of
+// any stored state (no vnode table to maintain), at the cost of the usual
+// jump-hash tradeoff: growing the cluster count reshuffles ownership for
+// ~1/n of keys rather than a more surgical vnode remap.
+type replicationRing struct {
+	numClusters int
+	rf          int
+}
+
+// newReplicationRing returns a ring over numClusters clusters, each key
+// owned by rf of them. An rf outside [1, numClusters] (including 0 or a
+// negative value) is treated as numClusters, so every cluster owns every key.
+func newReplicationRing(numClusters, rf int) *replicationRing {
+	if rf <= 0 || rf > numClusters {
+		rf = numClusters
+	}
+	return &replicationRing{numClusters: numClusters, rf: rf}
+}
+
+// owners returns the indices, in [0, numClusters), of the rf clusters
+// that own key. The result is stable for a given key and ring.
+func (r *replicationRing) owners(key string) []int {
+	if r.rf >= r.numClusters {
+		indices := make([]int, r.numClusters)
+		for i := range indices {
+			indices[i] = i
+		}
+		return indices
+	}
+	start := jumpHash(key, r.numClusters)
+	owners := make([]int, r.rf)
+	for i := range owners {
+		owners[i] = (start + i) % r.numClusters
+	}
+	return owners
+}
+
+// jumpHash implements Google's jump consistent hash
+// (https://arxiv.org/abs/1406.2294), a fast, allocation-free way to map a
+// key to one of numBuckets buckets such that, when numBuckets changes,
+// only a ~1/numBuckets fraction of keys move to a different bucket.
+func jumpHash(key string, numBuckets int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	k := h.Sum64()
+
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		k = k*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((k>>33)+1)))
+	}
+	return int(b)
+}
+
+// ownerGroup is a set of cluster indices together with the keys owned by
+// exactly that set. Grouping keys this way lets write and read strategies
+// issue one batched call per distinct owner set instead of one round trip
+// per key.
+type ownerGroup struct {
+	indices []int
+	keys    []string
+}
+
+// groupByOwners partitions keys by the set of cluster indices that own
+// each one. If the farm has no replication ring configured (the default),
+// every key is owned by every cluster, so there's always exactly one
+// group containing all the keys.
+func (f *Farm) groupByOwners(keys []string) []ownerGroup {
+	if f.ring == nil {
+		all := make([]int, len(f.clusters))
+		for i := range all {
+			all[i] = i
+		}
+		return []ownerGroup{{indices: all, keys: keys}}
+	}
+
+	groups := map[string]*ownerGroup{}
+	order := []string{}
+	for _, key := range keys {
+		indices := f.ring.owners(key)
+		groupKey := ownerGroupKey(indices)
+		g, ok := groups[groupKey]
+		if !ok {
+			g = &ownerGroup{indices: indices}
+			groups[groupKey] = g
+			order = append(order, groupKey)
+		}
+		g.keys = append(g.keys, key)
+	}
+
+	result := make([]ownerGroup, len(order))
+	for i, groupKey := range order {
+		result[i] = *groups[groupKey]
+	}
+	return result
+}
+
+// ownerGroupKey returns a canonical map key for a set of cluster indices,
+// suitable for grouping keys that share the same owners.
+func ownerGroupKey(indices []int) string {
+	parts := make([]string, len(indices))
+	for i, idx := range indices {
+		parts[i] = strconv.Itoa(idx)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,80 @@
+package farm
+
+import "testing"
+
+func TestReplicationRingOwnersIsStable(t *testing.T) {
+	r := newReplicationRing(5, 2)
+	first := r.owners("some-key")
+	for i := 0; i < 10; i++ {
+		if got := r.owners("some-key"); !equalInts(got, first) {
+			t.Fatalf("owners(%q) = %v on call %d, want stable %v", "some-key", got, i, first)
+		}
+	}
+}
+
+func TestReplicationRingOwnersCount(t *testing.T) {
+	for _, testCase := range []struct {
+		numClusters, rf int
+	}{
+		{5, 2}, {5, 1}, {5, 5}, {5, 8} /* rf clamped to numClusters */, {1, 1},
+	} {
+		r := newReplicationRing(testCase.numClusters, testCase.rf)
+		owners := r.owners("a-key")
+		wantLen := testCase.rf
+		if wantLen <= 0 || wantLen > testCase.numClusters {
+			wantLen = testCase.numClusters
+		}
+		if len(owners) != wantLen {
+			t.Errorf("newReplicationRing(%d, %d).owners() returned %d owners, want %d", testCase.numClusters, testCase.rf, len(owners), wantLen)
+		}
+		seen := map[int]bool{}
+		for _, i := range owners {
+			if i < 0 || i >= testCase.numClusters {
+				t.Errorf("newReplicationRing(%d, %d).owners() returned out-of-range index %d", testCase.numClusters, testCase.rf, i)
+			}
+			if seen[i] {
+				t.Errorf("newReplicationRing(%d, %d).owners() returned duplicate index %d", testCase.numClusters, testCase.rf, i)
+			}
+			seen[i] = true
+		}
+	}
+}
+
+func TestJumpHashDistribution(t *testing.T) {
+	const numBuckets = 4
+	counts := make([]int, numBuckets)
+	for i := 0; i < 4000; i++ {
+		b := jumpHash(keyForIndex(i), numBuckets)
+		if b < 0 || b >= numBuckets {
+			t.Fatalf("jumpHash returned out-of-range bucket %d", b)
+		}
+		counts[b]++
+	}
+	for b, c := range counts {
+		if c < 500 {
+			t.Errorf("bucket %d got only %d of 4000 keys, want a roughly even split", b, c)
+		}
+	}
+}
+
+func keyForIndex(i int) string {
+	digits := "0123456789"
+	s := make([]byte, 0, 8)
+	for i > 0 || len(s) == 0 {
+		s = append([]byte{digits[i%10]}, s...)
+		i /= 10
+	}
+	return "key-" + string(s)
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
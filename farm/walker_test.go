@@ -0,0 +1,24 @@
+package farm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRatePolicePace(t *testing.T) {
+	for _, testCase := range []struct {
+		name         string
+		waitInterval time.Duration
+		batchSize    int
+		want         int
+	}{
+		{"one batch per second", time.Second, 100, 100},
+		{"two batches per second", 500 * time.Millisecond, 100, 200},
+		{"sub-one rate rounds up to 1", time.Hour, 1, 1},
+		{"non-positive interval returns the batch size as-is", 0, 100, 100},
+	} {
+		if got := ratePolicePace(testCase.waitInterval, testCase.batchSize); got != testCase.want {
+			t.Errorf("%s: ratePolicePace(%v, %d) = %d, want %d", testCase.name, testCase.waitInterval, testCase.batchSize, got, testCase.want)
+		}
+	}
+}